@@ -0,0 +1,181 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/u-root/u-root/pkg/mount"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+	"github.com/u-root/u-root/pkg/securelaunch/tpm"
+)
+
+// defaultAlgorithm is the digest algorithm used when a collector's policy
+// does not specify Algorithms.
+const defaultAlgorithm = "sha256"
+
+// defaultEventLogFile is the sibling file, next to each collector's main
+// persisted artifact, that accumulates one entry per successful Collect
+// call recording which PCR/banks were actually extended; a Collect call
+// that returns an error adds no entry. A verifier working
+// offline from a quote reads this file to know which banks it can
+// reconstruct -- a runtime debug line is not persisted and is useless to
+// it.
+const defaultEventLogFile = "eventlog.json"
+
+/*
+ * bankPolicy is embedded by collectors to let a policy select a specific
+ * PCR and a specific set of TPM banks to extend, instead of the implicit
+ * single-bank SHA-1 extend collectors used to perform. It is not itself
+ * unmarshalled as a nested JSON object; collectors embed its fields
+ * directly so the policy file shape (`"pcr": N, "algorithms": [...]`)
+ * stays flat like the rest of a collector's JSON.
+ */
+type bankPolicy struct {
+	// PCR is the PCR index to extend. Zero means "use the package
+	// default", matching the pre-existing single-PCR behavior.
+	PCR int `json:"pcr"`
+
+	// Algorithms lists the TPM banks to extend, e.g.
+	// ["sha256","sha384"]. Empty means sha256 only.
+	Algorithms []string `json:"algorithms"`
+
+	// AllowSHA1 must be set to explicitly opt into a SHA-1-only
+	// Algorithms list. Collectors refuse to extend SHA-1 alone
+	// otherwise, since a SHA-1-only quote is not meaningful evidence
+	// against a well-resourced adversary.
+	AllowSHA1 bool `json:"allow_sha1"`
+}
+
+/*
+ * resolvePCR returns the PCR to extend for this collector: the
+ * policy-configured PCR if set, else the package default.
+ */
+func (b bankPolicy) resolvePCR() int {
+	if b.PCR != 0 {
+		return b.PCR
+	}
+	return pcr
+}
+
+/*
+ * resolveAlgorithms returns the TPM banks to extend for this collector,
+ * defaulting to sha256. It rejects, upfront, any requested algorithm that
+ * tpm.ExtendPCRBanks cannot actually produce a digest for -- a policy
+ * asking for a bank this build doesn't support must fail loudly rather
+ * than silently extending a subset of what it asked for. It also refuses
+ * a SHA-1-only configuration unless AllowSHA1 is set.
+ */
+func (b bankPolicy) resolveAlgorithms() ([]string, error) {
+	algs := b.Algorithms
+	if len(algs) == 0 {
+		algs = []string{defaultAlgorithm}
+	}
+
+	supported := make(map[string]bool)
+	for _, a := range tpm.SupportedAlgorithms() {
+		supported[a] = true
+	}
+
+	sha1Only := true
+	for _, a := range algs {
+		if !supported[a] {
+			return nil, fmt.Errorf("measurement: unsupported TPM bank algorithm %q (supported: %v)", a, tpm.SupportedAlgorithms())
+		}
+		if a != "sha1" {
+			sha1Only = false
+		}
+	}
+	if sha1Only && !b.AllowSHA1 {
+		return nil, fmt.Errorf("measurement: SHA-1-only bank configuration requires AllowSHA1: true")
+	}
+
+	return algs, nil
+}
+
+/*
+ * extendRecord is one entry of a collector's persisted event log: which
+ * collector ran, which PCR it extended, and which banks succeeded.
+ */
+type extendRecord struct {
+	Collector string   `json:"collector"`
+	PCR       int      `json:"pcr"`
+	Banks     []string `json:"banks"`
+}
+
+/*
+ * persistMeasurement writes a collector's measured data to disk via
+ * persist, then appends an extendRecord to the sibling event log so a
+ * verifier can learn which PCR/banks were extended without needing
+ * runtime debug output.
+ */
+func persistMeasurement(data []byte, targetPath, defaultFile, collector string, pcrNum int, banks []string) error {
+	if e := persist(data, targetPath, defaultFile); e != nil {
+		return e
+	}
+	return appendEventLog(targetPath, extendRecord{Collector: collector, PCR: pcrNum, Banks: banks})
+}
+
+/*
+ * mergeEventLog decodes existing as a JSON array of extendRecord (treating
+ * a missing/empty/corrupt existing as an empty log, since the event log is
+ * supplementary evidence and not the measurement itself), appends rec, and
+ * re-encodes the result. Pulled out of appendEventLog as a pure function so
+ * the append-across-calls and corrupt-log-reset behavior can be tested
+ * without going through slaunch's mount-backed file I/O.
+ */
+func mergeEventLog(existing []byte, rec extendRecord) ([]byte, error) {
+	var records []extendRecord
+	if len(existing) > 0 {
+		if err := json.Unmarshal(existing, &records); err != nil {
+			records = nil
+		}
+	}
+	records = append(records, rec)
+
+	return json.Marshal(records)
+}
+
+/*
+ * appendEventLog mounts targetPath's volume, reads the existing
+ * eventlog.json array next to targetPath (if any), merges rec into it via
+ * mergeEventLog, and writes the result back.
+ */
+func appendEventLog(targetPath string, rec extendRecord) error {
+	filePath, mountPath, r := slaunch.GetMountedFilePath(targetPath, 0) // 0 is flag for rw mount option
+	if r != nil {
+		return fmt.Errorf("EventLog: ERR: input %s could NOT be located, err=%v", targetPath, r)
+	}
+
+	dir := filepath.Dir(filePath)
+	if fi, err := os.Stat(filePath); err == nil && fi.IsDir() {
+		// targetPath names a directory: persist wrote the artifact inside it.
+		dir = filePath
+	}
+	logPath := filepath.Join(dir, defaultEventLogFile)
+
+	existing, _ := os.ReadFile(logPath) // missing file -> empty log, see mergeEventLog
+
+	d, err := mergeEventLog(existing, rec)
+	if err != nil {
+		if ret := mount.Unmount(mountPath, true, false); ret != nil {
+			log.Printf("Unmount failed. PANIC")
+			panic(ret)
+		}
+		return err
+	}
+
+	_, err = slaunch.WriteToFile(d, dir, defaultEventLogFile)
+	if ret := mount.Unmount(mountPath, true, false); ret != nil {
+		log.Printf("Unmount failed. PANIC")
+		panic(ret)
+	}
+
+	return err
+}
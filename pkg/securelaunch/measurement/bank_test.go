@@ -0,0 +1,152 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResolveAlgorithms(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		policy  bankPolicy
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "default is sha256",
+			policy: bankPolicy{},
+			want:   []string{"sha256"},
+		},
+		{
+			name:   "explicit multi-bank",
+			policy: bankPolicy{Algorithms: []string{"sha256", "sha384"}},
+			want:   []string{"sha256", "sha384"},
+		},
+		{
+			name:    "sha1-only rejected without AllowSHA1",
+			policy:  bankPolicy{Algorithms: []string{"sha1"}},
+			wantErr: true,
+		},
+		{
+			name:   "sha1-only allowed with AllowSHA1",
+			policy: bankPolicy{Algorithms: []string{"sha1"}, AllowSHA1: true},
+			want:   []string{"sha1"},
+		},
+		{
+			name:   "sha1 alongside a stronger bank needs no AllowSHA1",
+			policy: bankPolicy{Algorithms: []string{"sha1", "sha256"}},
+			want:   []string{"sha1", "sha256"},
+		},
+		{
+			name:    "unsupported algorithm rejected",
+			policy:  bankPolicy{Algorithms: []string{"sha1", "sm3_256"}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.resolveAlgorithms()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveAlgorithms() = %v, nil, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveAlgorithms() unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolveAlgorithms() = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("resolveAlgorithms()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolvePCR(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy bankPolicy
+		want   int
+	}{
+		{
+			name:   "policy PCR overrides default",
+			policy: bankPolicy{PCR: 16},
+			want:   16,
+		},
+		{
+			name:   "zero falls back to package default",
+			policy: bankPolicy{},
+			want:   pcr,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.resolvePCR(); got != tt.want {
+				t.Errorf("resolvePCR() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeEventLog(t *testing.T) {
+	rec := extendRecord{Collector: "cpuid", PCR: 17, Banks: []string{"sha256", "sha384"}}
+
+	for _, tt := range []struct {
+		name     string
+		existing []byte
+		want     []extendRecord
+	}{
+		{
+			name:     "missing log starts a new array",
+			existing: nil,
+			want:     []extendRecord{rec},
+		},
+		{
+			name:     "empty log starts a new array",
+			existing: []byte(""),
+			want:     []extendRecord{rec},
+		},
+		{
+			name:     "corrupt log is reset rather than failing",
+			existing: []byte("not json"),
+			want:     []extendRecord{rec},
+		},
+		{
+			name:     "valid log is appended to",
+			existing: []byte(`[{"collector":"runtime","pcr":16,"banks":["sha256"]}]`),
+			want: []extendRecord{
+				{Collector: "runtime", PCR: 16, Banks: []string{"sha256"}},
+				rec,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := mergeEventLog(tt.existing, rec)
+			if err != nil {
+				t.Fatalf("mergeEventLog() unexpected error: %v", err)
+			}
+
+			var got []extendRecord
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("mergeEventLog() produced invalid JSON: %v: %s", err, b)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("mergeEventLog() = %s, want %d records matching %+v", b, len(tt.want), tt.want)
+			}
+			for i := range tt.want {
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("mergeEventLog()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -10,9 +10,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strings"
 
-	"github.com/intel-go/cpuid"
+	"github.com/klauspost/cpuid/v2"
 	"github.com/u-root/u-root/pkg/mount"
 	slaunch "github.com/u-root/u-root/pkg/securelaunch"
 	"github.com/u-root/u-root/pkg/securelaunch/tpm"
@@ -20,12 +21,32 @@ import (
 
 const (
 	defaultCPUIDFile = "cpuid.txt" //only used if user doesn't provide any
+
+	// x64LevelFailDigest is extended into the PCR in place of the real
+	// CPUID measurement when RequireX64Level is set and the running CPU
+	// does not meet it. It lets a verifier distinguish "collector did not
+	// run" from "CPU failed the ISA baseline check" in the quote.
+	x64LevelFailDigest = "securelaunch: cpuid x86-64 microarchitecture level requirement not met"
 )
 
 /* describes the "cpuid" portion of policy file */
 type CPUIDCollector struct {
 	Type     string `json:"type"`
 	Location string `json:"location"`
+
+	// Format selects the persisted/measured payload encoding: "text"
+	// (default, human readable) or "json" (canonical, sorted/fixed
+	// field order, for attestation verifiers to parse directly).
+	Format string `json:"format"`
+
+	// RequireX64Level, if non-zero, is the minimum x86-64 psABI
+	// microarchitecture level (1-4) the running CPU must report via
+	// cpuid.CPU.X64Level(). If the running CPU is below this level,
+	// Collect extends a well-known failure digest into the PCR and
+	// returns an error instead of measuring the CPUID data.
+	RequireX64Level int `json:"require_x64_level"`
+
+	bankPolicy
 }
 
 /*
@@ -43,102 +64,155 @@ func NewCPUIDCollector(config []byte) (Collector, error) {
 	return fc, nil
 }
 
+/* cpuidReport is the canonical JSON document measured/persisted when
+ * Format is "json". Field order is fixed (alphabetical by Go field name)
+ * and Features is sorted, so two collectors observing the same CPU always
+ * produce byte-identical output. */
+type cpuidReport struct {
+	BrandName          string   `json:"brand_name"`
+	CacheLine          int      `json:"cache_line"`
+	Family             int      `json:"family"`
+	Features           []string `json:"features"`
+	L1DataCache        int      `json:"l1_data_cache"`
+	L1InstructionCache int      `json:"l1_instruction_cache"`
+	L2Cache            int      `json:"l2_cache"`
+	L3Cache            int      `json:"l3_cache"`
+	LogicalCores       int      `json:"logical_cores"`
+	Model              int      `json:"model"`
+	PhysicalCores      int      `json:"physical_cores"`
+	Stepping           int      `json:"stepping"`
+	VendorString       string   `json:"vendor_string"`
+	X64Level           int      `json:"x64_level"`
+}
+
+/*
+ * newCPUIDReport builds a cpuidReport from klauspost/cpuid/v2's detected
+ * cpuid.CPU, sorting the feature set so the JSON encoding is canonical.
+ */
+func newCPUIDReport() cpuidReport {
+	features := cpuid.CPU.FeatureSet().Strings()
+	sort.Strings(features)
+
+	return cpuidReport{
+		BrandName:          cpuid.CPU.BrandName,
+		CacheLine:          cpuid.CPU.CacheLine,
+		Family:             cpuid.CPU.Family,
+		Features:           features,
+		L1DataCache:        cpuid.CPU.Cache.L1D,
+		L1InstructionCache: cpuid.CPU.Cache.L1I,
+		L2Cache:            cpuid.CPU.Cache.L2,
+		L3Cache:            cpuid.CPU.Cache.L3,
+		LogicalCores:       cpuid.CPU.LogicalCores,
+		Model:              cpuid.CPU.Model,
+		PhysicalCores:      cpuid.CPU.PhysicalCores,
+		Stepping:           cpuid.CPU.Stepping,
+		VendorString:       cpuid.CPU.VendorString,
+		X64Level:           cpuid.CPU.X64Level(),
+	}
+}
+
 /*
- * getCPUIDInfo used a string builder to store data obtained from intel-go/cpuid package.
- * returns a byte slice of the string built via string builder.
+ * getCPUIDInfo uses a string builder to store data obtained from the
+ * klauspost/cpuid/v2 package. returns a byte slice of the string built via
+ * string builder.
  */
 func getCPUIDInfo() []byte {
 	var w strings.Builder
-	fmt.Fprintf(&w, "VendorString:           %s\n", cpuid.VendorIdentificatorString)
-	fmt.Fprintf(&w, "ProcessorBrandString:   %s\n", cpuid.ProcessorBrandString)
-	fmt.Fprintf(&w, "SteppingId:     %d\n", cpuid.SteppingId)
-	fmt.Fprintf(&w, "ProcessorType:  %d\n", cpuid.ProcessorType)
-	fmt.Fprintf(&w, "DisplayFamily:  %d\n", cpuid.DisplayFamily)
-	fmt.Fprintf(&w, "DisplayModel:   %d\n", cpuid.DisplayModel)
-	fmt.Fprintf(&w, "CacheLineSize:  %d\n", cpuid.CacheLineSize)
-	fmt.Fprintf(&w, "MaxLogocalCPUId:%d\n", cpuid.MaxLogocalCPUId)
-	fmt.Fprintf(&w, "InitialAPICId:  %d\n", cpuid.InitialAPICId)
-	fmt.Fprintf(&w, "Smallest monitor-line size in bytes:  %d\n", cpuid.MonLineSizeMin)
-	fmt.Fprintf(&w, "Largest monitor-line size in bytes:   %d\n", cpuid.MonLineSizeMax)
-	fmt.Fprintf(&w, "Monitor Interrupt break-event is supported:  %v\n", cpuid.MonitorIBE)
-	fmt.Fprintf(&w, "MONITOR/MWAIT extensions are supported:      %v\n", cpuid.MonitorEMX)
-	fmt.Fprintf(&w, "AVX state:     %v\n", cpuid.EnabledAVX)
-	fmt.Fprintf(&w, "AVX-512 state: %v\n", cpuid.EnabledAVX512)
-	fmt.Fprintf(&w, "Interrupt thresholds in digital thermal sensor: %v\n", cpuid.ThermalSensorInterruptThresholds)
-
-	fmt.Fprintf(&w, "Features: ")
-	for i := uint64(0); i < 64; i++ {
-		if cpuid.HasFeature(1 << i) {
-			fmt.Fprintf(&w, "%s ", cpuid.FeatureNames[1<<i])
-		}
-	}
-	fmt.Fprintf(&w, "\n")
+	r := newCPUIDReport()
+
+	fmt.Fprintf(&w, "VendorString:         %s\n", r.VendorString)
+	fmt.Fprintf(&w, "BrandName:            %s\n", r.BrandName)
+	fmt.Fprintf(&w, "Family:               %d\n", r.Family)
+	fmt.Fprintf(&w, "Model:                %d\n", r.Model)
+	fmt.Fprintf(&w, "Stepping:             %d\n", r.Stepping)
+	fmt.Fprintf(&w, "CacheLine:            %d\n", r.CacheLine)
+	fmt.Fprintf(&w, "L1 Data Cache:        %d\n", r.L1DataCache)
+	fmt.Fprintf(&w, "L1 Instruction Cache: %d\n", r.L1InstructionCache)
+	fmt.Fprintf(&w, "L2 Cache:             %d\n", r.L2Cache)
+	fmt.Fprintf(&w, "L3 Cache:             %d\n", r.L3Cache)
+	fmt.Fprintf(&w, "Physical Cores:       %d\n", r.PhysicalCores)
+	fmt.Fprintf(&w, "Logical Cores:        %d\n", r.LogicalCores)
+	fmt.Fprintf(&w, "x86-64 level:         %d\n", r.X64Level)
+
+	fmt.Fprintf(&w, "Features: %s\n", strings.Join(r.Features, " "))
 
-	fmt.Fprintf(&w, "ExtendedFeatures: ")
-	for i := uint64(0); i < 64; i++ {
-		if cpuid.HasExtendedFeature(1 << i) {
-			fmt.Fprintf(&w, "%s ", cpuid.ExtendedFeatureNames[1<<i])
-		}
-	}
-	fmt.Fprintf(&w, "\n")
+	return []byte(w.String())
+}
 
-	fmt.Fprintf(&w, "ExtraFeatures: ")
-	for i := uint64(0); i < 64; i++ {
-		if cpuid.HasExtraFeature(1 << i) {
-			fmt.Fprintf(&w, "%s ", cpuid.ExtraFeatureNames[1<<i])
-		}
+/*
+ * getCPUIDInfoJSON returns the canonical JSON encoding of the CPUID report,
+ * suitable for an attestation verifier to parse without screen-scraping.
+ */
+func getCPUIDInfoJSON() ([]byte, error) {
+	return json.Marshal(newCPUIDReport())
+}
+
+/*
+ * measureCPUIDFile stores the CPUIDInfo obtained from the cpuid package
+ * into the tpm device, encoded per s.Format. If s.RequireX64Level is set
+ * and unmet, a well-known failure digest is extended instead and an error
+ * is returned so the launch policy can gate boot on it. It returns the
+ * data measured, the PCR extended, and the banks successfully extended,
+ * so Collect can record the latter two in the persisted event log.
+ */
+func (s *CPUIDCollector) measureCPUIDFile(tpmHandle io.ReadWriteCloser) ([]byte, int, []string, error) {
+	algs, err := s.resolveAlgorithms()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("CPUID Collector: %w", err)
 	}
-	fmt.Fprintf(&w, "\n")
+	targetPCR := s.resolvePCR()
 
-	fmt.Fprintf(&w, "ThermalAndPowerFeatures: ")
-	for i := uint32(0); i < 64; i++ {
-		if cpuid.HasThermalAndPowerFeature(1 << i) {
-			if name, found := cpuid.ThermalAndPowerFeatureNames[1<<i]; found {
-				fmt.Fprintf(&w, "%s ", name)
+	if s.RequireX64Level > 0 {
+		if level := cpuid.CPU.X64Level(); level < s.RequireX64Level {
+			if _, e := tpm.ExtendPCRBanks(tpmHandle, targetPCR, algs, strings.NewReader(x64LevelFailDigest)); e != nil {
+				return nil, 0, nil, e
 			}
+			return nil, 0, nil, fmt.Errorf("CPUID Collector: x86-64 level %d does not meet required level %d", level, s.RequireX64Level)
 		}
 	}
-	fmt.Fprintf(&w, "\n")
 
-	for _, cacheDescription := range cpuid.CacheDescriptors {
-		fmt.Fprintf(&w, "CacheDescriptor: %v\n", cacheDescription)
+	var d []byte
+	switch s.Format {
+	case "", "text":
+		d = getCPUIDInfo()
+	case "json":
+		j, err := getCPUIDInfoJSON()
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("CPUID Collector: failed to marshal json: %w", err)
+		}
+		d = j
+	default:
+		return nil, 0, nil, fmt.Errorf("CPUID Collector: unknown format %q, expected \"text\" or \"json\"", s.Format)
 	}
 
-	return []byte(w.String())
-}
-
-/*
- * measureCPUIDFile stores the CPUIDInfo obtained from cpuid package
- * into the tpm device */
-func measureCPUIDFile(tpmHandle io.ReadWriteCloser) ([]byte, error) {
-
-	d := getCPUIDInfo() // return strings builder
-	if e := tpm.ExtendPCRDebug(tpmHandle, pcr, bytes.NewReader(d)); e != nil {
-		return nil, e
+	banks, e := tpm.ExtendPCRBanks(tpmHandle, targetPCR, algs, bytes.NewReader(d))
+	if e != nil {
+		return nil, 0, nil, e
 	}
 
-	return d, nil
+	return d, targetPCR, banks, nil
 }
 
 /*
- * persist stores the cpuid info obtained from cpuid package into a file on disk.
- * disk where target file is located is first mounted and unmounted shortly after
- * write operation is completed. An error is returned if mount or unmount of disk,
- * where target is located, fails _OR_ writing to disk fails.
- * - data - byte slice of the cpuid data obtained from cpuid package.
- * - cpuidTargetPath - target file path on disk where cpuid info should be copied.
+ * persist stores collector output into a file on disk. disk where target
+ * file is located is first mounted and unmounted shortly after write
+ * operation is completed. An error is returned if mount or unmount of
+ * disk, where target is located, fails _OR_ writing to disk fails.
+ * - data - byte slice of the data obtained from a collector.
+ * - targetPath - target file path on disk where data should be copied.
+ * - defaultFile - file name to use if targetPath names a directory.
  */
-func persist(data []byte, cpuidTargetPath string) error {
+func persist(data []byte, targetPath string, defaultFile string) error {
 
-	// cpuidTargetPath is of form sda:/boot/cpuid.txt
-	filePath, mountPath, r := slaunch.GetMountedFilePath(cpuidTargetPath, 0) // 0 is flag for rw mount option
+	// targetPath is of form sda:/boot/cpuid.txt
+	filePath, mountPath, r := slaunch.GetMountedFilePath(targetPath, 0) // 0 is flag for rw mount option
 	if r != nil {
-		return fmt.Errorf("EventLog: ERR: input %s could NOT be located, err=%v", cpuidTargetPath, r)
+		return fmt.Errorf("EventLog: ERR: input %s could NOT be located, err=%v", targetPath, r)
 	}
 
 	dst := filePath // /tmp/boot-733276578/cpuid
 
-	target, err := slaunch.WriteToFile(data, dst, defaultCPUIDFile)
+	target, err := slaunch.WriteToFile(data, dst, defaultFile)
 	if ret := mount.Unmount(mountPath, true, false); ret != nil {
 		log.Printf("Unmount failed. PANIC")
 		panic(ret)
@@ -161,13 +235,13 @@ func persist(data []byte, cpuidTargetPath string) error {
  */
 func (s *CPUIDCollector) Collect(tpmHandle io.ReadWriteCloser) error {
 
-	d, err := measureCPUIDFile(tpmHandle)
+	d, usedPCR, banks, err := s.measureCPUIDFile(tpmHandle)
 	if err != nil {
 		log.Printf("CPUID Collector: err = %v", err)
 		return err
 	}
 
-	if e := persist(d, s.Location); e != nil {
+	if e := persistMeasurement(d, s.Location, defaultCPUIDFile, "cpuid", usedPCR, banks); e != nil {
 		log.Printf("CPUID Collector: err= %s", e)
 		return e
 	}
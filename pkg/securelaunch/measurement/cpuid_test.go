@@ -0,0 +1,52 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestCpuidReportFieldOrder locks down the canonical (alphabetical) JSON key
+// order of cpuidReport. An attestation verifier diffs this output across
+// machines byte-for-byte, so a field reorder here is a breaking change even
+// though Go doesn't care about struct field order.
+func TestCpuidReportFieldOrder(t *testing.T) {
+	want := []string{
+		"brand_name",
+		"cache_line",
+		"family",
+		"features",
+		"l1_data_cache",
+		"l1_instruction_cache",
+		"l2_cache",
+		"l3_cache",
+		"logical_cores",
+		"model",
+		"physical_cores",
+		"stepping",
+		"vendor_string",
+		"x64_level",
+	}
+
+	b, err := json.Marshal(cpuidReport{})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	out := string(b)
+
+	last := -1
+	for _, key := range want {
+		idx := strings.Index(out, `"`+key+`"`)
+		if idx == -1 {
+			t.Fatalf("field %q missing from marshalled cpuidReport: %s", key, out)
+		}
+		if idx < last {
+			t.Fatalf("field %q out of order in marshalled cpuidReport: %s", key, out)
+		}
+		last = idx
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/mount"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+	"github.com/u-root/u-root/pkg/securelaunch/tpm"
+)
+
+const (
+	defaultRuntimeFile = "runtime.txt" // only used if user doesn't provide any
+
+	sysCPUPath = "/sys/devices/system/cpu"
+)
+
+// sysCgroupPath and procCmdlinePath are vars rather than consts so tests can
+// point them at a temporary directory instead of the real sysfs/procfs.
+var (
+	sysCgroupPath   = "/sys/fs/cgroup"
+	procCmdlinePath = "/proc/cmdline"
+)
+
+/* describes the "runtime" portion of policy file */
+type RuntimeCollector struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+
+	bankPolicy
+}
+
+/*
+ * NewRuntimeCollector extracts the "runtime" portion from the policy file,
+ * initializes a new RuntimeCollector structure and returns error
+ * if unmarshalling of RuntimeCollector fails
+ */
+func NewRuntimeCollector(config []byte) (Collector, error) {
+	slaunch.Debug("New Runtime Collector initialized\n")
+	var fc = new(RuntimeCollector)
+	err := json.Unmarshal(config, &fc)
+	if err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+/* cgroupLimits holds the cgroup v1/v2 CPU quota and memory limit observed
+ * for the current process' cgroup, if any. A limit of -1 means
+ * "unlimited" or "not set"; Version 0 means no cgroup hierarchy was found. */
+type cgroupLimits struct {
+	CPUQuotaUs       int64 `json:"cpu_quota_us"`
+	CPUPeriodUs      int64 `json:"cpu_period_us"`
+	MemoryLimitBytes int64 `json:"memory_limit_bytes"`
+	Version          int   `json:"version"`
+}
+
+/* runtimeReport is the canonical JSON document measured/persisted by
+ * RuntimeCollector. Field order is fixed (alphabetical by Go field name)
+ * and slices are sorted, so two runs against the same execution
+ * environment always produce byte-identical output. */
+type runtimeReport struct {
+	Cgroup           cgroupLimits      `json:"cgroup"`
+	CmdlineIsolation map[string]string `json:"cmdline_isolation"`
+	GOMAXPROCS       int               `json:"gomaxprocs"`
+	NumaNodes        []string          `json:"numa_nodes"`
+	OfflineCPUs      string            `json:"offline_cpus"`
+	OnlineCPUs       string            `json:"online_cpus"`
+}
+
+/*
+ * readSysFile reads a sysfs/procfs file and returns its trimmed content, or
+ * "" if the file does not exist. Missing files are common: a given kernel
+ * or cgroup version may simply not expose a particular knob.
+ */
+func readSysFile(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+/*
+ * readCgroupLimits detects whether the current process is confined by a
+ * cgroup v2 unified hierarchy or a cgroup v1 cpu/memory hierarchy, and
+ * parses out the CPU quota/period and memory limit. Unlimited values
+ * ("max" on v2, -1 or unset on v1) are reported as -1.
+ */
+func readCgroupLimits() cgroupLimits {
+	limits := cgroupLimits{CPUQuotaUs: -1, CPUPeriodUs: -1, MemoryLimitBytes: -1}
+
+	if _, err := os.Stat(filepath.Join(sysCgroupPath, "cgroup.controllers")); err == nil {
+		limits.Version = 2
+		if max := readSysFile(filepath.Join(sysCgroupPath, "cpu.max")); max != "" {
+			fields := strings.Fields(max)
+			if len(fields) == 2 && fields[0] != "max" {
+				if q, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+					limits.CPUQuotaUs = q
+				}
+				if p, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					limits.CPUPeriodUs = p
+				}
+			}
+		}
+		if mem := readSysFile(filepath.Join(sysCgroupPath, "memory.max")); mem != "" && mem != "max" {
+			if m, err := strconv.ParseInt(mem, 10, 64); err == nil {
+				limits.MemoryLimitBytes = m
+			}
+		}
+		return limits
+	}
+
+	if _, err := os.Stat(filepath.Join(sysCgroupPath, "cpu")); err == nil {
+		limits.Version = 1
+		if q := readSysFile(filepath.Join(sysCgroupPath, "cpu", "cpu.cfs_quota_us")); q != "" {
+			if v, err := strconv.ParseInt(q, 10, 64); err == nil {
+				limits.CPUQuotaUs = v
+			}
+		}
+		if p := readSysFile(filepath.Join(sysCgroupPath, "cpu", "cpu.cfs_period_us")); p != "" {
+			if v, err := strconv.ParseInt(p, 10, 64); err == nil {
+				limits.CPUPeriodUs = v
+			}
+		}
+		if mem := readSysFile(filepath.Join(sysCgroupPath, "memory", "memory.limit_in_bytes")); mem != "" {
+			if v, err := strconv.ParseInt(mem, 10, 64); err == nil {
+				limits.MemoryLimitBytes = v
+			}
+		}
+	}
+
+	return limits
+}
+
+/*
+ * readNumaNodes returns the sorted list of NUMA node directory names
+ * exposed under sysCPUPath's sibling /sys/devices/system/node, e.g.
+ * ["node0", "node1"]. Returns an empty slice on non-NUMA or unreadable
+ * systems.
+ */
+func readNumaNodes() []string {
+	entries, err := os.ReadDir("/sys/devices/system/node")
+	if err != nil {
+		return []string{}
+	}
+
+	var nodes []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "node") {
+			nodes = append(nodes, e.Name())
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+/*
+ * readCmdlineIsolation parses /proc/cmdline for the subset of kernel
+ * parameters that affect runtime isolation (isolcpus, nohz_full,
+ * mitigations), returning only the ones actually present.
+ */
+func readCmdlineIsolation() map[string]string {
+	keys := []string{"isolcpus", "nohz_full", "mitigations"}
+	want := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		want[k] = true
+	}
+
+	result := map[string]string{}
+	for _, tok := range strings.Fields(readSysFile(procCmdlinePath)) {
+		kv := strings.SplitN(tok, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if want[kv[0]] {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
+
+/*
+ * newRuntimeReport gathers the runtime execution constraints: CPU
+ * online/offline masks, NUMA topology, cgroup CPU/memory
+ * limits, GOMAXPROCS, and kernel isolation cmdline parameters.
+ */
+func newRuntimeReport() runtimeReport {
+	return runtimeReport{
+		Cgroup:           readCgroupLimits(),
+		CmdlineIsolation: readCmdlineIsolation(),
+		GOMAXPROCS:       runtime.GOMAXPROCS(0),
+		NumaNodes:        readNumaNodes(),
+		OfflineCPUs:      readSysFile(filepath.Join(sysCPUPath, "offline")),
+		OnlineCPUs:       readSysFile(filepath.Join(sysCPUPath, "online")),
+	}
+}
+
+/*
+ * getRuntimeInfo returns the canonical JSON encoding of the runtime
+ * report, matching the serialization style used for persisted/measured
+ * collector payloads elsewhere in this package.
+ */
+func getRuntimeInfo() ([]byte, error) {
+	return json.Marshal(newRuntimeReport())
+}
+
+/*
+ * measureRuntimeFile stores the runtime info into the tpm device. It
+ * returns the data measured, the PCR extended, and the banks successfully
+ * extended, so Collect can record the latter two in the persisted event
+ * log.
+ */
+func (s *RuntimeCollector) measureRuntimeFile(tpmHandle io.ReadWriteCloser) ([]byte, int, []string, error) {
+	d, err := getRuntimeInfo()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("Runtime Collector: failed to marshal json: %w", err)
+	}
+
+	algs, err := s.resolveAlgorithms()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("Runtime Collector: %w", err)
+	}
+
+	targetPCR := s.resolvePCR()
+	banks, e := tpm.ExtendPCRBanks(tpmHandle, targetPCR, algs, bytes.NewReader(d))
+	if e != nil {
+		return nil, 0, nil, e
+	}
+
+	return d, targetPCR, banks, nil
+}
+
+/*
+ * Collect satisfies collector interface. It calls various functions to
+ * 1. get the runtime info (cgroup/cpu topology/cmdline isolation params)
+ * 2. stores hash of the result in the tpm device.
+ * 3. also keeps a copy of the result on disk at location provided in policy file.
+ */
+func (s *RuntimeCollector) Collect(tpmHandle io.ReadWriteCloser) error {
+
+	d, usedPCR, banks, err := s.measureRuntimeFile(tpmHandle)
+	if err != nil {
+		log.Printf("Runtime Collector: err = %v", err)
+		return err
+	}
+
+	if e := persistMeasurement(d, s.Location, defaultRuntimeFile, "runtime", usedPCR, banks); e != nil {
+		log.Printf("Runtime Collector: err= %s", e)
+		return e
+	}
+	return nil
+}
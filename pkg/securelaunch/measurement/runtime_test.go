@@ -0,0 +1,154 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCgroupLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string // path relative to the fake cgroup root -> content
+		want  cgroupLimits
+	}{
+		{
+			name: "v2 bounded",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"cpu.max":            "100000 100000",
+				"memory.max":         "1073741824",
+			},
+			want: cgroupLimits{CPUQuotaUs: 100000, CPUPeriodUs: 100000, MemoryLimitBytes: 1073741824, Version: 2},
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"cpu.max":            "max 100000",
+				"memory.max":         "max",
+			},
+			want: cgroupLimits{CPUQuotaUs: -1, CPUPeriodUs: -1, MemoryLimitBytes: -1, Version: 2},
+		},
+		{
+			name: "v1 bounded",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000",
+				"cpu/cpu.cfs_period_us":        "100000",
+				"memory/memory.limit_in_bytes": "536870912",
+			},
+			want: cgroupLimits{CPUQuotaUs: 50000, CPUPeriodUs: 100000, MemoryLimitBytes: 536870912, Version: 1},
+		},
+		{
+			name:  "neither hierarchy present",
+			files: map[string]string{},
+			want:  cgroupLimits{CPUQuotaUs: -1, CPUPeriodUs: -1, MemoryLimitBytes: -1, Version: 0},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for rel, content := range tt.files {
+				p := filepath.Join(root, rel)
+				if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+					t.Fatalf("MkdirAll: %v", err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			orig := sysCgroupPath
+			sysCgroupPath = root
+			defer func() { sysCgroupPath = orig }()
+
+			got := readCgroupLimits()
+			if got != tt.want {
+				t.Errorf("readCgroupLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCmdlineIsolation(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cmdline string
+		want    map[string]string
+	}{
+		{
+			name:    "all isolation params present",
+			cmdline: "BOOT_IMAGE=/vmlinuz isolcpus=2,3 nohz_full=2,3 mitigations=off quiet",
+			want:    map[string]string{"isolcpus": "2,3", "nohz_full": "2,3", "mitigations": "off"},
+		},
+		{
+			name:    "none present",
+			cmdline: "BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro quiet",
+			want:    map[string]string{},
+		},
+		{
+			name:    "malformed tokens ignored",
+			cmdline: "isolcpus=1 noarg mitigations",
+			want:    map[string]string{"isolcpus": "1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := filepath.Join(dir, "cmdline")
+			if err := os.WriteFile(p, []byte(tt.cmdline), 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			orig := procCmdlinePath
+			procCmdlinePath = p
+			defer func() { procCmdlinePath = orig }()
+
+			got := readCmdlineIsolation()
+			if len(got) != len(tt.want) {
+				t.Fatalf("readCmdlineIsolation() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("readCmdlineIsolation()[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+// TestRuntimeReportFieldOrder locks down the canonical (alphabetical) JSON
+// key order of runtimeReport, matching the field-order guarantee
+// runtimeReport's doc comment makes.
+func TestRuntimeReportFieldOrder(t *testing.T) {
+	want := []string{
+		"cgroup",
+		"cmdline_isolation",
+		"gomaxprocs",
+		"numa_nodes",
+		"offline_cpus",
+		"online_cpus",
+	}
+
+	b, err := json.Marshal(runtimeReport{})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	out := string(b)
+
+	last := -1
+	for _, key := range want {
+		idx := strings.Index(out, `"`+key+`"`)
+		if idx == -1 {
+			t.Fatalf("field %q missing from marshalled runtimeReport: %s", key, out)
+		}
+		if idx < last {
+			t.Fatalf("field %q out of order in marshalled runtimeReport: %s", key, out)
+		}
+		last = idx
+	}
+}
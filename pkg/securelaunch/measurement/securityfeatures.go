@@ -0,0 +1,330 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/cpuid/v2"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+	"github.com/u-root/u-root/pkg/securelaunch/tpm"
+)
+
+const (
+	defaultSecurityFeaturesFile = "securityfeatures.txt" // only used if user doesn't provide any
+
+	txtPublicSpacePath = "/sys/kernel/security/txt"
+
+	msrFeatureControl = 0x3A       // IA32_FEATURE_CONTROL
+	msrDebugCtl       = 0x1D9      // IA32_DEBUG_CTL
+	msrSEVStatus      = 0xC0010131 // AMD SEV_STATUS
+	msrSeamrrMask     = 0x1401     // IA32_SEAMRR_MASK
+
+	// seamrrEnabled and seamrrLocked are the "Enable" (bit 10) and "Lock"
+	// (bit 11) fields of IA32_SEAMRR_MASK (0x1401), which follow the same
+	// Lock/Enable convention as the legacy SMRR range registers (Intel
+	// SDM Vol. 3C section 2.5.2, "System Management Range Registers
+	// (SMRR)") per the Intel TDX Module Base Architecture Specification's
+	// description of SEAMRR_PHYS_MASK. Both must be set for the platform
+	// to actually have TDX host (SEAM) support turned on -- the CPUID
+	// TDX_GUEST bit only tells us whether *this* CPU is itself running
+	// as a TDX guest, which is a different question entirely.
+	seamrrEnabled = 1 << 10
+	seamrrLocked  = 1 << 11
+)
+
+/* describes the "securityfeatures" portion of policy file */
+type SecurityFeaturesCollector struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+
+	bankPolicy
+}
+
+/*
+ * NewSecurityFeaturesCollector extracts the "securityfeatures" portion
+ * from the policy file, initializes a new SecurityFeaturesCollector
+ * structure and returns error if unmarshalling fails.
+ */
+func NewSecurityFeaturesCollector(config []byte) (Collector, error) {
+	slaunch.Debug("New SecurityFeatures Collector initialized\n")
+	var fc = new(SecurityFeaturesCollector)
+	err := json.Unmarshal(config, &fc)
+	if err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+/* sgxReport describes SGX support and launch-control state from CPUID
+ * leaf 0x12, as exposed by cpuid.CPU.SGX. */
+type sgxReport struct {
+	Available     bool     `json:"available"`
+	EPCSections   []string `json:"epc_sections"`
+	LaunchControl bool     `json:"launch_control"`
+}
+
+/* sevReport describes AMD SEV/SEV-ES/SEV-SNP capability (CPUID
+ * 0x8000001F) and the running guest/host SEV_STATUS MSR (0xC0010131),
+ * when readable. */
+type sevReport struct {
+	SEV        bool   `json:"sev"`
+	SEVES      bool   `json:"sev_es"`
+	SEVSNP     bool   `json:"sev_snp"`
+	StatusMSR  uint64 `json:"status_msr"`
+	StatusRead bool   `json:"status_read"`
+}
+
+/* msrReport captures a handful of per-CPU MSR values relevant to a DRTM
+ * launch's trust story: whether VMX/locking has been disabled by
+ * firmware (IA32_FEATURE_CONTROL) and whether debug facilities that
+ * could undermine measured boot are enabled (IA32_DEBUG_CTL). Indexed by
+ * logical CPU number; a missing entry means the MSR could not be read
+ * (no /dev/cpu/N/msr, usually because the msr module isn't loaded). */
+type msrReport struct {
+	DebugCtl       map[string]uint64 `json:"debug_ctl"`
+	FeatureControl map[string]uint64 `json:"feature_control"`
+}
+
+/* txtReport holds the Intel TXT TXT.STS and TXT.ESTS register snapshot
+ * exposed by the kernel's security/txt interface. Both are "unavailable"
+ * on a platform/kernel that doesn't expose them. */
+type txtReport struct {
+	ESTS string `json:"ests"`
+	STS  string `json:"sts"`
+}
+
+/* securityFeaturesReport is the canonical JSON document measured/persisted
+ * by SecurityFeaturesCollector. Field order is fixed (alphabetical by Go
+ * field name). */
+type securityFeaturesReport struct {
+	MSR            msrReport `json:"msr"`
+	SEV            sevReport `json:"sev"`
+	SGX            sgxReport `json:"sgx"`
+	TDXHostSupport bool      `json:"tdx_host_support"`
+	TXT            txtReport `json:"txt"`
+}
+
+/*
+ * readMSR reads the 64-bit MSR at addr on logical CPU cpuNum via
+ * /dev/cpu/<cpuNum>/msr, which requires the msr kernel module to be
+ * loaded. Returns an error if the device cannot be opened/read, which is
+ * expected on systems without CAP_SYS_RAWIO or the msr module.
+ */
+func readMSR(cpuNum int, addr int64) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/dev/cpu/%d/msr", cpuNum))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var v uint64
+	if _, err := f.Seek(addr, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := binary.Read(f, binary.LittleEndian, &v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+/*
+ * onlineCPUNumbers returns the logical CPU numbers visible under
+ * sysCPUPath, used to iterate MSR reads across every online CPU.
+ */
+func onlineCPUNumbers() []int {
+	entries, err := os.ReadDir(sysCPUPath)
+	if err != nil {
+		return nil
+	}
+
+	var cpus []int
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "cpu%d", &n); err == nil {
+			cpus = append(cpus, n)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+/*
+ * readSGXReport reads SGX availability, EPC sections, and launch-control
+ * state from CPUID leaf 0x12 via cpuid.CPU.SGX.
+ */
+func readSGXReport() sgxReport {
+	r := sgxReport{
+		Available:     cpuid.CPU.SGX.Available,
+		LaunchControl: cpuid.CPU.SGX.LaunchControl,
+	}
+	for _, s := range cpuid.CPU.SGX.EPCSections {
+		r.EPCSections = append(r.EPCSections, fmt.Sprintf("base=0x%x size=0x%x", s.BaseAddress, s.EPCSize))
+	}
+	return r
+}
+
+/*
+ * readSEVReport reads AMD SEV/SEV-ES/SEV-SNP capability bits from CPUID
+ * 0x8000001F, plus the SEV_STATUS MSR (0xC0010131) on CPU 0 when readable
+ * (requires running under an SEV guest or having host MSR access).
+ */
+func readSEVReport() sevReport {
+	r := sevReport{
+		SEV:    cpuid.CPU.Supports(cpuid.SEV),
+		SEVES:  cpuid.CPU.Supports(cpuid.SEV_ES),
+		SEVSNP: cpuid.CPU.Supports(cpuid.SEV_SNP),
+	}
+
+	if v, err := readMSR(0, msrSEVStatus); err == nil {
+		r.StatusMSR = v
+		r.StatusRead = true
+	}
+
+	return r
+}
+
+/*
+ * readTDXHostSupport reports whether the platform itself has TDX host
+ * (SEAM) support turned on, by checking that IA32_SEAMRR_MASK reports
+ * both Enabled and Locked on CPU 0. This is independent of
+ * cpuid.TDX_GUEST, which instead reports whether the running CPU is
+ * itself executing as a TDX guest -- the opposite relationship from what
+ * TDXHostSupport needs to attest.
+ */
+func readTDXHostSupport() bool {
+	v, err := readMSR(0, msrSeamrrMask)
+	if err != nil {
+		return false
+	}
+	return seamrrEnabledAndLocked(v)
+}
+
+/*
+ * seamrrEnabledAndLocked reports whether a raw IA32_SEAMRR_MASK value has
+ * both the Enable and Lock bits set. Split out from readTDXHostSupport so
+ * the bit logic can be pinned with fabricated register values in tests,
+ * independent of whether /dev/cpu/0/msr is readable.
+ */
+func seamrrEnabledAndLocked(v uint64) bool {
+	return v&seamrrEnabled != 0 && v&seamrrLocked != 0
+}
+
+/*
+ * readTXTStatus returns the Intel TXT TXT.STS and TXT.ESTS register
+ * snapshot exposed under txtPublicSpacePath by the kernel's security/txt
+ * interface, or "unavailable" for either register the platform/kernel
+ * does not expose.
+ */
+func readTXTStatus() txtReport {
+	r := txtReport{STS: "unavailable", ESTS: "unavailable"}
+	if s := readSysFile(txtPublicSpacePath + "/sts"); s != "" {
+		r.STS = s
+	}
+	if s := readSysFile(txtPublicSpacePath + "/ests"); s != "" {
+		r.ESTS = s
+	}
+	return r
+}
+
+/*
+ * readMSRReport reads IA32_FEATURE_CONTROL and IA32_DEBUG_CTL across
+ * every online CPU, recording only the CPUs where the read succeeded.
+ */
+func readMSRReport() msrReport {
+	r := msrReport{
+		DebugCtl:       map[string]uint64{},
+		FeatureControl: map[string]uint64{},
+	}
+
+	for _, cpuNum := range onlineCPUNumbers() {
+		key := strconv.Itoa(cpuNum)
+		if v, err := readMSR(cpuNum, msrFeatureControl); err == nil {
+			r.FeatureControl[key] = v
+		}
+		if v, err := readMSR(cpuNum, msrDebugCtl); err == nil {
+			r.DebugCtl[key] = v
+		}
+	}
+
+	return r
+}
+
+/*
+ * newSecurityFeaturesReport gathers SGX, TDX, SEV, TXT and MSR state
+ * relevant to attesting a DRTM launch's trust story.
+ */
+func newSecurityFeaturesReport() securityFeaturesReport {
+	return securityFeaturesReport{
+		MSR:            readMSRReport(),
+		SEV:            readSEVReport(),
+		SGX:            readSGXReport(),
+		TDXHostSupport: readTDXHostSupport(),
+		TXT:            readTXTStatus(),
+	}
+}
+
+/*
+ * getSecurityFeaturesInfo returns the canonical JSON encoding of the
+ * security features report.
+ */
+func getSecurityFeaturesInfo() ([]byte, error) {
+	return json.Marshal(newSecurityFeaturesReport())
+}
+
+/*
+ * measureSecurityFeaturesFile stores the security features info into the
+ * tpm device. It returns the data measured, the PCR extended, and the
+ * banks successfully extended, so Collect can record the latter two in
+ * the persisted event log.
+ */
+func (s *SecurityFeaturesCollector) measureSecurityFeaturesFile(tpmHandle io.ReadWriteCloser) ([]byte, int, []string, error) {
+	d, err := getSecurityFeaturesInfo()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("SecurityFeatures Collector: failed to marshal json: %w", err)
+	}
+
+	algs, err := s.resolveAlgorithms()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("SecurityFeatures Collector: %w", err)
+	}
+
+	targetPCR := s.resolvePCR()
+	banks, e := tpm.ExtendPCRBanks(tpmHandle, targetPCR, algs, bytes.NewReader(d))
+	if e != nil {
+		return nil, 0, nil, e
+	}
+
+	return d, targetPCR, banks, nil
+}
+
+/*
+ * Collect satisfies collector interface. It calls various functions to
+ * 1. get the SGX/TDX/SEV/TXT/MSR security feature state
+ * 2. stores hash of the result in the tpm device.
+ * 3. also keeps a copy of the result on disk at location provided in policy file.
+ */
+func (s *SecurityFeaturesCollector) Collect(tpmHandle io.ReadWriteCloser) error {
+
+	d, usedPCR, banks, err := s.measureSecurityFeaturesFile(tpmHandle)
+	if err != nil {
+		log.Printf("SecurityFeatures Collector: err = %v", err)
+		return err
+	}
+
+	if e := persistMeasurement(d, s.Location, defaultSecurityFeaturesFile, "securityfeatures", usedPCR, banks); e != nil {
+		log.Printf("SecurityFeatures Collector: err= %s", e)
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measurement
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestSecurityFeaturesReportFieldOrder locks down the canonical
+// (alphabetical) top-level JSON key order of securityFeaturesReport,
+// matching the field-order guarantee this package's other report types
+// make. The nested txtReport order is checked by TestTXTReportFieldOrder.
+func TestSecurityFeaturesReportFieldOrder(t *testing.T) {
+	want := []string{
+		"msr",
+		"sev",
+		"sgx",
+		"tdx_host_support",
+		"txt",
+	}
+
+	b, err := json.Marshal(securityFeaturesReport{})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	out := string(b)
+
+	last := -1
+	for _, key := range want {
+		idx := strings.Index(out, `"`+key+`"`)
+		if idx == -1 {
+			t.Fatalf("field %q missing from marshalled securityFeaturesReport: %s", key, out)
+		}
+		if idx < last {
+			t.Fatalf("field %q out of order in marshalled securityFeaturesReport: %s", key, out)
+		}
+		last = idx
+	}
+}
+
+func TestTXTReportFieldOrder(t *testing.T) {
+	b, err := json.Marshal(txtReport{STS: "sts-val", ESTS: "ests-val"})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	out := string(b)
+
+	estsIdx := strings.Index(out, `"ests"`)
+	stsIdx := strings.Index(out, `"sts"`)
+	if estsIdx == -1 || stsIdx == -1 {
+		t.Fatalf("ests/sts missing from marshalled txtReport: %s", out)
+	}
+	if estsIdx > stsIdx {
+		t.Errorf("txtReport field order = ests after sts, want ests before sts: %s", out)
+	}
+}
+
+func TestReadTDXHostSupport(t *testing.T) {
+	// readMSR opens /dev/cpu/0/msr directly, which is unavailable in this
+	// sandbox, so readTDXHostSupport must fail closed rather than error.
+	if got := readTDXHostSupport(); got {
+		t.Errorf("readTDXHostSupport() = true without a readable SEAMRR MSR, want false")
+	}
+}
+
+func TestSeamrrEnabledAndLocked(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		v    uint64
+		want bool
+	}{
+		{name: "neither bit set", v: 0, want: false},
+		{name: "enabled only", v: seamrrEnabled, want: false},
+		{name: "locked only", v: seamrrLocked, want: false},
+		{name: "enabled and locked", v: seamrrEnabled | seamrrLocked, want: true},
+		{name: "enabled and locked plus other bits", v: seamrrEnabled | seamrrLocked | 0x1, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seamrrEnabledAndLocked(tt.v); got != tt.want {
+				t.Errorf("seamrrEnabledAndLocked(0x%x) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tpm provides helpers used by securelaunch's measurement
+// collectors to extend PCRs on whatever TPM device is present.
+package tpm
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	slaunch "github.com/u-root/u-root/pkg/securelaunch"
+)
+
+/*
+ * ExtendPCRDebug extends the given pcr with the SHA-1 digest of the data
+ * read from r, logging the pcr and extended banks for debugging. It is kept for
+ * collectors/policies that have not opted into ExtendPCRBanks' explicit,
+ * multi-algorithm bank selection.
+ */
+func ExtendPCRDebug(tpmHandle io.ReadWriteCloser, pcr int, r io.Reader) error {
+	banks, err := ExtendPCRBanks(tpmHandle, pcr, []string{"sha1"}, r)
+	if err != nil {
+		return err
+	}
+	slaunch.Debug("tpm: ExtendPCRDebug: pcr=%d banks=%v", pcr, banks)
+	return nil
+}
+
+/*
+ * ExtendPCRBanks reads all of r once, computes the digest for each
+ * algorithm in algs, and issues a PCR_Extend for pcr against every
+ * requested bank in a single pass. It returns the list of banks that
+ * were successfully extended, in the same order as algs, so callers can
+ * record in the event log exactly which banks a verifier will be able to
+ * reconstruct the quote from.
+ *
+ * Supported algs: see SupportedAlgorithms. If any requested algorithm
+ * cannot be digested, or any individual PCR_Extend fails, the whole call
+ * fails -- a caller asking for N banks either gets all N extended or an
+ * error, never a silent subset. Callers should validate algs against
+ * SupportedAlgorithms before calling if they want to reject an
+ * unsupported bank before touching the TPM at all.
+ */
+func ExtendPCRBanks(tpmHandle io.ReadWriteCloser, pcr int, algs []string, r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: ExtendPCRBanks: failed to read input: %w", err)
+	}
+
+	extended := make([]string, 0, len(algs))
+	for _, alg := range algs {
+		digest, tpmAlg, err := digestFor(alg, data)
+		if err != nil {
+			return nil, fmt.Errorf("tpm: ExtendPCRBanks: pcr=%d: %w", pcr, err)
+		}
+
+		if err := tpm2.PCRExtend(tpmHandle, tpmutil.Handle(pcr), tpmAlg, digest, ""); err != nil {
+			return nil, fmt.Errorf("tpm: ExtendPCRBanks: pcr=%d alg=%s: %w", pcr, alg, err)
+		}
+
+		extended = append(extended, alg)
+	}
+
+	return extended, nil
+}
+
+/*
+ * SupportedAlgorithms returns the TPM bank digest algorithms this build
+ * of ExtendPCRBanks can actually produce. Callers resolving a policy's
+ * requested Algorithms against this list can reject an unsupported bank
+ * upfront, rather than have it silently fail inside ExtendPCRBanks.
+ */
+func SupportedAlgorithms() []string {
+	return []string{"sha1", "sha256", "sha384"}
+}
+
+/*
+ * digestFor computes the digest of data for the named algorithm and
+ * returns the corresponding go-tpm tpm2.Algorithm identifier.
+ */
+func digestFor(alg string, data []byte) ([]byte, tpm2.Algorithm, error) {
+	switch alg {
+	case "sha1":
+		d := sha1.Sum(data) //nolint:gosec
+		return d[:], tpm2.AlgSHA1, nil
+	case "sha256":
+		d := sha256.Sum256(data)
+		return d[:], tpm2.AlgSHA256, nil
+	case "sha384":
+		d := sha512.Sum384(data)
+		return d[:], tpm2.AlgSHA384, nil
+	default:
+		return nil, 0, fmt.Errorf("tpm: digestFor: unsupported algorithm %q", alg)
+	}
+}